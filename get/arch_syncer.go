@@ -0,0 +1,187 @@
+package get
+
+import (
+	"archive/tar"
+	"bufio"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// ArchPackage is a package entry parsed out of a pacman repo's <repo>.db desc file
+type ArchPackage struct {
+	// Filename is the package's own file name, relative to the repo's URL
+	Filename string
+	// Arch is the target architecture, e.g. "x86_64" or "any"
+	Arch string
+	// CSize is the compressed (on-disk) package size in bytes
+	CSize int64
+	// Sha256Sum is the package's SHA-256 checksum, hex-encoded
+	Sha256Sum string
+	// PgpSig is the package's detached PGP signature, base64-encoded, if any
+	PgpSig string
+}
+
+// ArchSyncer syncs pacman repos (Arch Linux and derivatives) from an HTTP
+// source to a Storage. It speaks the <repo>.db format: a compressed tar of
+// per-package "desc" files, rather than RPM's repomd.xml/primary.xml.
+type ArchSyncer struct {
+	repoBase
+	// RepoName is the repo's name, used to derive the <RepoName>.db file name
+	RepoName string
+}
+
+// NewArchSyncer creates a new ArchSyncer. parallelism is the number of
+// packages downloaded concurrently; values below 1 are treated as 1 (serial).
+func NewArchSyncer(url string, repoName string, archs map[string]bool, storage Storage, parallelism int) *ArchSyncer {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &ArchSyncer{repoBase{url, parallelism, archs, storage}, repoName}
+}
+
+// StoreRepo stores an HTTP pacman repo in a Storage
+func (r *ArchSyncer) StoreRepo() (err error) {
+	packagesToDownload, packagesToRecycle, err := r.processDB()
+	if err != nil {
+		return
+	}
+
+	refs := make([]downloadRef, len(packagesToDownload))
+	for i, pack := range packagesToDownload {
+		refs[i] = downloadRef{pack.Filename, pack.Sha256Sum}
+	}
+
+	log.Printf("Downloading %v packages with %v workers...\n", len(refs), r.parallelism)
+	err = r.downloadAll(refs)
+	if err != nil {
+		return
+	}
+
+	recycleCount := len(packagesToRecycle)
+	log.Printf("Recycling %v packages...\n", recycleCount)
+	for _, pack := range packagesToRecycle {
+		err = r.storage.Recycle(pack.Filename)
+		if err != nil {
+			return
+		}
+	}
+
+	log.Printf("Committing changes...\n")
+	return r.storage.Commit()
+}
+
+// processDB stores the repo's <RepoName>.db and returns the packages to
+// download and the ones that are already up to date and can be recycled
+func (r *ArchSyncer) processDB() (packagesToDownload []ArchPackage, packagesToRecycle []ArchPackage, err error) {
+	dbPath := r.RepoName + ".db"
+	err = r.downloadStoreApply(dbPath, "", func(reader io.ReadCloser) (err error) {
+		decompressedReader, err := openCompressed(reader)
+		if err != nil {
+			return
+		}
+		defer decompressedReader.Close()
+
+		packages, err := parseArchDB(decompressedReader)
+		if err != nil {
+			return
+		}
+
+		allArchs := len(r.archs) == 0
+		for _, pack := range packages {
+			if !allArchs && pack.Arch != "any" && !r.archs[pack.Arch] {
+				continue
+			}
+
+			storageChecksum, err := r.storage.Checksum(pack.Filename, SHA256)
+			switch {
+			case err == ErrFileNotFound:
+				log.Printf("...package '%v' not found, will be downloaded\n", pack.Filename)
+				packagesToDownload = append(packagesToDownload, pack)
+			case err != nil:
+				log.Printf("Checksum evaluation of the package '%v' returned the following error:\n", pack.Filename)
+				log.Printf("Error message: %v\n", err)
+				log.Println("...package skipped")
+			case pack.Sha256Sum != storageChecksum:
+				log.Printf("...package '%v' has a checksum error, will be redownloaded\n", pack.Filename)
+				log.Printf("[repo vs local] = ['%v' VS '%v']\n", pack.Sha256Sum, storageChecksum)
+				packagesToDownload = append(packagesToDownload, pack)
+			default:
+				log.Printf("...package '%v' is up-to-date already, will be recycled\n", pack.Filename)
+				packagesToRecycle = append(packagesToRecycle, pack)
+			}
+		}
+		return
+	})
+	return
+}
+
+// parseArchDB reads a <repo>.db tar stream and returns one ArchPackage per
+// "desc" entry it contains
+func parseArchDB(reader io.Reader) (packages []ArchPackage, err error) {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return packages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if path.Base(header.Name) != "desc" {
+			continue
+		}
+
+		pack, err := parseArchDesc(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, pack)
+	}
+}
+
+// parseArchDesc parses a single desc file's %KEY%\nvalue\n\n sections
+func parseArchDesc(reader io.Reader) (pack ArchPackage, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var key string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+			key = strings.Trim(line, "%")
+		case line == "":
+			key = ""
+		case key != "":
+			switch key {
+			case "FILENAME":
+				pack.Filename = line
+			case "ARCH":
+				pack.Arch = line
+			case "CSIZE":
+				pack.CSize = parseArchInt(line)
+			case "SHA256SUM":
+				pack.Sha256Sum = line
+			case "PGPSIG":
+				pack.PgpSig = line
+			}
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// parseArchInt parses a desc file integer field, returning 0 on malformed input
+func parseArchInt(s string) int64 {
+	var n int64
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n
+}
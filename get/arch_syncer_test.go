@@ -0,0 +1,105 @@
+package get
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const sampleDesc = `%FILENAME%
+somepkg-1.0-1-x86_64.pkg.tar.zst
+
+%ARCH%
+x86_64
+
+%CSIZE%
+12345
+
+%SHA256SUM%
+deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef
+
+%PGPSIG%
+c2lnbmF0dXJl
+`
+
+func TestParseArchDesc(t *testing.T) {
+	pack, err := parseArchDesc(strings.NewReader(sampleDesc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pack.Filename != "somepkg-1.0-1-x86_64.pkg.tar.zst" {
+		t.Errorf("Filename = %q", pack.Filename)
+	}
+	if pack.Arch != "x86_64" {
+		t.Errorf("Arch = %q", pack.Arch)
+	}
+	if pack.CSize != 12345 {
+		t.Errorf("CSize = %v", pack.CSize)
+	}
+	if pack.Sha256Sum != "deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef" {
+		t.Errorf("Sha256Sum = %q", pack.Sha256Sum)
+	}
+	if pack.PgpSig != "c2lnbmF0dXJl" {
+		t.Errorf("PgpSig = %q", pack.PgpSig)
+	}
+}
+
+func TestParseArchDescMissingFields(t *testing.T) {
+	pack, err := parseArchDesc(strings.NewReader("%FILENAME%\nonly-this.pkg.tar.zst\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pack.Filename != "only-this.pkg.tar.zst" {
+		t.Errorf("Filename = %q", pack.Filename)
+	}
+	if pack.Arch != "" || pack.CSize != 0 || pack.Sha256Sum != "" || pack.PgpSig != "" {
+		t.Errorf("expected unset fields to stay zero, got %+v", pack)
+	}
+}
+
+func TestParseArchDB(t *testing.T) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+
+	writeDescEntry(t, tarWriter, "somepkg-1.0-1/desc", sampleDesc)
+	writeDescEntry(t, tarWriter, "otherpkg-2.0-1/desc", strings.ReplaceAll(sampleDesc, "somepkg-1.0-1-x86_64.pkg.tar.zst", "otherpkg-2.0-1-x86_64.pkg.tar.zst"))
+	// a non-desc entry (pacman .db archives also ship a "files" file per package) must be ignored
+	writeDescEntry(t, tarWriter, "somepkg-1.0-1/files", "usr/bin/somepkg\n")
+
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	packages, err := parseArchDB(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(packages) != 2 {
+		t.Fatalf("expected 2 packages, got %v: %+v", len(packages), packages)
+	}
+	if packages[0].Filename != "somepkg-1.0-1-x86_64.pkg.tar.zst" {
+		t.Errorf("packages[0].Filename = %q", packages[0].Filename)
+	}
+	if packages[1].Filename != "otherpkg-2.0-1-x86_64.pkg.tar.zst" {
+		t.Errorf("packages[1].Filename = %q", packages[1].Filename)
+	}
+}
+
+func writeDescEntry(t *testing.T, tarWriter *tar.Writer, name string, content string) {
+	t.Helper()
+
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(len(content)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tarWriter.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package get
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic = []byte{0x1F, 0x8B}
+	zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+	xzMagic   = []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A}
+)
+
+// openCompressed sniffs the first bytes of reader and returns a ReadCloser
+// decompressing it, supporting gzip, Zstandard and xz. It is used by
+// processPrimary and is meant to be reused by any future decompressor
+// (deltainfo, filelists, updateinfo) that faces the same mix of formats.
+func openCompressed(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+
+	header, err := buffered.Peek(len(xzMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case hasPrefix(header, gzipMagic):
+		return gzip.NewReader(buffered)
+	case hasPrefix(header, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case hasPrefix(header, xzMagic):
+		xzReader, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xzReader), nil
+	default:
+		return nil, fmt.Errorf("unrecognized compression format, header bytes: %x", header)
+	}
+}
+
+// hasPrefix reports whether header starts with magic, tolerating header
+// being shorter than magic for streams too short to sniff fully
+func hasPrefix(header []byte, magic []byte) bool {
+	if len(header) < len(magic) {
+		return false
+	}
+	for i, b := range magic {
+		if header[i] != b {
+			return false
+		}
+	}
+	return true
+}
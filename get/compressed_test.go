@@ -0,0 +1,106 @@
+package get
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestOpenCompressedGzip(t *testing.T) {
+	const payload = "hello gzip"
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertOpenCompressedRoundTrips(t, buf.Bytes(), payload)
+}
+
+func TestOpenCompressedZstd(t *testing.T) {
+	const payload = "hello zstd"
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := encoder.EncodeAll([]byte(payload), nil)
+
+	assertOpenCompressedRoundTrips(t, compressed, payload)
+}
+
+func TestOpenCompressedXz(t *testing.T) {
+	const payload = "hello xz"
+
+	var buf bytes.Buffer
+	writer, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertOpenCompressedRoundTrips(t, buf.Bytes(), payload)
+}
+
+func TestOpenCompressedUnrecognized(t *testing.T) {
+	_, err := openCompressed(bytes.NewReader([]byte("not compressed at all")))
+	if err == nil {
+		t.Fatal("expected an error for an unrecognized format, got nil")
+	}
+}
+
+func TestOpenCompressedShortStream(t *testing.T) {
+	// a gzip stream of an empty payload: only its header and trailer (about
+	// 20 bytes, still longer than the longest magic number) decode to nothing
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := openCompressed(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 0 {
+		t.Fatalf("expected empty output, got %q", result)
+	}
+}
+
+func assertOpenCompressedRoundTrips(t *testing.T, compressed []byte, want string) {
+	t.Helper()
+
+	reader, err := openCompressed(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,188 @@
+package get
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/moio/minima/util"
+)
+
+// RepoSyncer is implemented by anything that can mirror one repo format
+// (RPM's Syncer, pacman's ArchSyncer, ...) into a Storage
+type RepoSyncer interface {
+	StoreRepo() error
+}
+
+// repoBase holds the fields and download machinery shared by every repo
+// format's syncer: the source URL, the arch filter, the destination
+// Storage, and the bounded worker pool used to fetch packages concurrently
+type repoBase struct {
+	// Url of the repo this syncer syncs
+	Url string
+	// parallelism is the number of packages downloaded concurrently
+	parallelism int
+	archs       map[string]bool
+	storage     Storage
+}
+
+// downloadRef is a repo-format-agnostic reference to a file to download:
+// a repo-relative path and the checksum it is expected to have once stored
+type downloadRef struct {
+	href     string
+	checksum string
+}
+
+// downloadProgress aggregates bytes and files downloaded across workers
+type downloadProgress struct {
+	total     int64
+	doneFiles int64
+	doneBytes int64
+}
+
+// reportFile records one more completed download of the given size and logs
+// aggregate progress across all workers
+func (p *downloadProgress) reportFile(size int64) {
+	doneFiles := atomic.AddInt64(&p.doneFiles, 1)
+	doneBytes := atomic.AddInt64(&p.doneBytes, size)
+	log.Printf("...%v/%v packages downloaded (%v bytes so far)\n", doneFiles, p.total, doneBytes)
+}
+
+// countingConsumer returns a util.ReaderConsumer that drains reader and
+// reports the number of bytes read to progress once done
+func countingConsumer(progress *downloadProgress) util.ReaderConsumer {
+	return func(reader io.ReadCloser) (err error) {
+		n, err := io.Copy(ioutil.Discard, reader)
+		if err != nil {
+			return
+		}
+		progress.reportFile(n)
+		return
+	}
+}
+
+// downloadAll downloads every ref in refs using up to r.parallelism
+// concurrent workers, stopping promptly after the first error
+func (r *repoBase) downloadAll(refs []downloadRef) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := make(chan downloadRef)
+	firstErr := make(chan error, 1)
+	progress := &downloadProgress{total: int64(len(refs))}
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range jobs {
+				select {
+				case <-ctx.Done():
+					continue
+				default:
+				}
+
+				// countingConsumer already reports aggregate progress for this
+				// batch, so skip downloadStoreApply's per-file log line here
+				err := r.downloadStoreApplyQuiet(ref.href, ref.checksum, countingConsumer(progress))
+				if err != nil {
+					select {
+					case firstErr <- err:
+						cancel()
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, ref := range refs {
+		select {
+		case jobs <- ref:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	select {
+	case err := <-firstErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// downloadStore downloads a repo-relative path into a file
+func (r *repoBase) downloadStore(path string) error {
+	return r.downloadStoreApply(path, "", util.Nop)
+}
+
+// downloadStoreVerified downloads a repo-relative path into storage and, if
+// expectedChecksum is non-empty, verifies it against the just-downloaded
+// bytes directly, without reading back through Storage.Checksum: for
+// S3Storage the live object does not exist until Commit promotes it, so a
+// read-back would always report ErrFileNotFound for metadata staged in the
+// same sync.
+func (r *repoBase) downloadStoreVerified(path string, expectedChecksum string, expectedChecksumType ChecksumType) error {
+	return r.downloadStoreApply(path, "", func(reader io.ReadCloser) (err error) {
+		if expectedChecksum == "" {
+			_, err = io.Copy(ioutil.Discard, reader)
+			return
+		}
+
+		h := newHash(expectedChecksumType)
+		if _, err = io.Copy(h, reader); err != nil {
+			return
+		}
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != expectedChecksum {
+			return fmt.Errorf("checksum mismatch for %v: expected %v, got %v", path, expectedChecksum, actual)
+		}
+		return
+	})
+}
+
+// downloadStoreApply downloads a repo-relative path into a file, while applying a ReaderConsumer
+func (r *repoBase) downloadStoreApply(path string, checksum string, f util.ReaderConsumer) error {
+	log.Printf("Downloading %v...", path)
+	return r.downloadStoreApplyQuiet(path, checksum, f)
+}
+
+// downloadStoreApplyQuiet is downloadStoreApply without the per-file log
+// line, for callers (downloadAll's workers) that already report their own
+// progress instead
+func (r *repoBase) downloadStoreApplyQuiet(path string, checksum string, f util.ReaderConsumer) error {
+	return downloadApply(r.Url+"/"+path, util.Compose(r.storage.StoringMapper(path, checksum), f))
+}
+
+// downloadApply is DownloadApply, indirected through a package variable so
+// tests can substitute a fake downloader instead of making real HTTP requests
+var downloadApply = DownloadApply
+
+// downloadBytesIgnoring404 downloads a repo-relative path and returns its
+// content, returning a nil slice (and no error) if the path is missing
+func (r *repoBase) downloadBytesIgnoring404(path string) (data []byte, err error) {
+	buf := &bytes.Buffer{}
+	err = r.downloadStoreApply(path, "", func(reader io.ReadCloser) (err error) {
+		_, err = io.Copy(buf, reader)
+		return
+	})
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "404") {
+			log.Printf("Got 404 for %v, ignoring...", path)
+			return nil, nil
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
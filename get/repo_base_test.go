@@ -0,0 +1,127 @@
+package get
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/moio/minima/util"
+)
+
+// fakeStorage is a minimal Storage that just drains and records what it was asked to store
+type fakeStorage struct {
+	mutex  sync.Mutex
+	stored []string
+}
+
+func (f *fakeStorage) StoringMapper(path string, checksum string) util.ReaderConsumer {
+	return func(reader io.ReadCloser) (err error) {
+		defer reader.Close()
+		if _, err = io.Copy(ioutil.Discard, reader); err != nil {
+			return
+		}
+		f.mutex.Lock()
+		f.stored = append(f.stored, path)
+		f.mutex.Unlock()
+		return
+	}
+}
+
+func (f *fakeStorage) Checksum(path string, checksumType ChecksumType) (string, error) {
+	return "", ErrFileNotFound
+}
+
+func (f *fakeStorage) Recycle(path string) error { return nil }
+func (f *fakeStorage) Commit() error             { return nil }
+
+func withFakeDownloadApply(t *testing.T, fn func(url string, consumer util.ReaderConsumer) error) {
+	t.Helper()
+	original := downloadApply
+	downloadApply = fn
+	t.Cleanup(func() { downloadApply = original })
+}
+
+func makeRefs(n int) []downloadRef {
+	refs := make([]downloadRef, n)
+	for i := range refs {
+		refs[i] = downloadRef{href: fmt.Sprintf("package-%d.rpm", i)}
+	}
+	return refs
+}
+
+func TestDownloadAllHappyPath(t *testing.T) {
+	withFakeDownloadApply(t, func(url string, consumer util.ReaderConsumer) error {
+		return consumer(ioutil.NopCloser(strings.NewReader("package bytes")))
+	})
+
+	storage := &fakeStorage{}
+	r := &repoBase{Url: "http://example.test/repo", parallelism: 4, storage: storage}
+	refs := makeRefs(10)
+
+	if err := r.downloadAll(refs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(storage.stored) != len(refs) {
+		t.Fatalf("got %v stored packages, want %v", len(storage.stored), len(refs))
+	}
+}
+
+func TestDownloadAllStopsAfterFirstError(t *testing.T) {
+	var attempts int64
+	boom := errors.New("boom")
+	withFakeDownloadApply(t, func(url string, consumer util.ReaderConsumer) error {
+		atomic.AddInt64(&attempts, 1)
+		return boom
+	})
+
+	storage := &fakeStorage{}
+	r := &repoBase{Url: "http://example.test/repo", parallelism: 2, storage: storage}
+	refs := makeRefs(1000)
+
+	err := r.downloadAll(refs)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if got := atomic.LoadInt64(&attempts); got >= int64(len(refs)) {
+		t.Errorf("downloadAll attempted all %v refs; the first error should have cancelled the rest, got %v attempts", len(refs), got)
+	}
+}
+
+func TestDownloadAllIsSerialWhenParallelismIsOne(t *testing.T) {
+	var inFlight, maxInFlight int32
+	withFakeDownloadApply(t, func(url string, consumer util.ReaderConsumer) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			old := atomic.LoadInt32(&maxInFlight)
+			if n <= old || atomic.CompareAndSwapInt32(&maxInFlight, old, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return consumer(ioutil.NopCloser(strings.NewReader("package bytes")))
+	})
+
+	storage := &fakeStorage{}
+	r := &repoBase{Url: "http://example.test/repo", parallelism: 1, storage: storage}
+	refs := makeRefs(10)
+
+	if err := r.downloadAll(refs); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxInFlight > 1 {
+		t.Errorf("parallelism=1 should download refs one at a time, got up to %v concurrent downloads", maxInFlight)
+	}
+	if len(storage.stored) != len(refs) {
+		t.Fatalf("got %v stored packages, want %v", len(storage.stored), len(refs))
+	}
+}
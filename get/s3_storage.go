@@ -0,0 +1,263 @@
+package get
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/moio/minima/util"
+)
+
+// manifestKey is the object Commit publishes once it has promoted every
+// staged file, listing everything that is live as of that Commit. Nothing in
+// this package reads it back; it exists for downstream consumers that want a
+// single object to check rather than listing the whole bucket.
+const manifestKey = ".minima-manifest"
+
+// stagingPrefix namespaces objects that have been uploaded but not yet committed
+const stagingPrefix = ".minima-staging/"
+
+// sha1MetadataKey and sha256MetadataKey name the S3 object metadata entries
+// StoringMapper stamps onto every object it stores, so Checksum can later
+// report a real SHA-1/SHA-256 checksum instead of the object's ETag (which is
+// its MD5, not one of the algorithms repo metadata uses, and is not even the
+// MD5 once an object went through multipart upload)
+const (
+	sha1MetadataKey   = "Minima-Sha1"
+	sha256MetadataKey = "Minima-Sha256"
+)
+
+// s3API is the subset of *s3.S3 that S3Storage needs, so tests can supply a
+// fake instead of talking to real S3
+type s3API interface {
+	HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	CopyObject(*s3.CopyObjectInput) (*s3.CopyObjectOutput, error)
+	DeleteObject(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+}
+
+// s3Uploader is the subset of *s3manager.Uploader that S3Storage needs
+type s3Uploader interface {
+	Upload(*s3manager.UploadInput, ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+// S3Config holds the parameters needed to address an S3-compatible bucket,
+// mirroring the surface transfer.sh exposes for its own S3 storage backend
+type S3Config struct {
+	// Endpoint overrides the AWS endpoint, for MinIO or other S3-compatible gateways
+	Endpoint string
+	// Region is the bucket's region
+	Region string
+	// Bucket is the name of the bucket to store data into
+	Bucket string
+	// AccessKey is the S3 access key
+	AccessKey string
+	// SecretKey is the S3 secret key
+	SecretKey string
+	// PathStyle forces path-style bucket addressing instead of virtual-host style
+	PathStyle bool
+	// NoMultipart disables multipart uploads, for gateways that do not support them
+	NoMultipart bool
+}
+
+// S3Storage stores data in an S3-compatible bucket
+type S3Storage struct {
+	config   S3Config
+	client   s3API
+	uploader s3Uploader
+
+	mutex    sync.Mutex
+	staged   []string // staged under stagingPrefix by StoringMapper, to be promoted on Commit
+	recycled []string // already live, recorded only so the manifest covers them too
+}
+
+// NewS3Storage returns a new Storage backed by an S3-compatible bucket
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	awsConfig := aws.NewConfig().
+		WithRegion(config.Region).
+		WithCredentials(credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, "")).
+		WithS3ForcePathStyle(config.PathStyle)
+	if config.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.New(sess)
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		if config.NoMultipart {
+			// a part size this large means objects below it are sent in a single PUT
+			u.PartSize = s3manager.MaxUploadParts * s3manager.DefaultUploadPartSize
+		}
+	})
+
+	return &S3Storage{config: config, client: client, uploader: uploader}, nil
+}
+
+// StoringMapper uploads the data read from reader into the bucket's staging
+// area, to be promoted into place on Commit. The SHA-1 and SHA-256 of the
+// uploaded bytes are stamped onto the object as metadata (carried over to the
+// live object when Commit promotes it), so a later Checksum call can report
+// a real checksum instead of the object's ETag. When checksum is non-empty
+// and recognized (see Storage.StoringMapper), the upload is verified against
+// it and removed from staging on mismatch.
+func (s *S3Storage) StoringMapper(filename string, checksum string) util.ReaderConsumer {
+	return func(reader io.ReadCloser) (err error) {
+		defer reader.Close()
+
+		sha1Hash := sha1.New()
+		sha256Hash := sha256.New()
+		body := io.TeeReader(reader, io.MultiWriter(sha1Hash, sha256Hash))
+
+		stagingKey := stagingPrefix + filename
+		_, err = s.uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(s.config.Bucket),
+			Key:    aws.String(stagingKey),
+			Body:   body,
+		})
+		if err != nil {
+			return
+		}
+
+		sha1Sum := hex.EncodeToString(sha1Hash.Sum(nil))
+		sha256Sum := hex.EncodeToString(sha256Hash.Sum(nil))
+
+		if checksumType, ok := checksumTypeFromLength(checksum); ok {
+			actual := sha1Sum
+			if checksumType == SHA256 {
+				actual = sha256Sum
+			}
+			if actual != checksum {
+				_, _ = s.client.DeleteObject(&s3.DeleteObjectInput{
+					Bucket: aws.String(s.config.Bucket),
+					Key:    aws.String(stagingKey),
+				})
+				return fmt.Errorf("checksum mismatch for %v: expected %v, got %v", filename, checksum, actual)
+			}
+		}
+
+		_, err = s.client.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(s.config.Bucket),
+			CopySource: aws.String(s.config.Bucket + "/" + stagingKey),
+			Key:        aws.String(stagingKey),
+			Metadata: map[string]*string{
+				sha1MetadataKey:   aws.String(sha1Sum),
+				sha256MetadataKey: aws.String(sha256Sum),
+			},
+			MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+		})
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.staged = append(s.staged, filename)
+		s.mutex.Unlock()
+		return
+	}
+}
+
+// Checksum returns the checksum of an already-committed object, read back
+// from the metadata StoringMapper stamped onto it at upload time (the
+// object's ETag is its MD5, not one of the algorithms repo metadata uses, so
+// it cannot serve this purpose)
+func (s *S3Storage) Checksum(filename string, checksumType ChecksumType) (result string, err error) {
+	out, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return "", ErrFileNotFound
+		}
+		return "", err
+	}
+
+	key := sha1MetadataKey
+	if checksumType == SHA256 {
+		key = sha256MetadataKey
+	}
+	if value := out.Metadata[key]; value != nil {
+		result = *value
+	}
+	return
+}
+
+// Recycle is a no-op for S3Storage: the object is already live at filename,
+// so there is nothing to copy. It is recorded so Commit's manifest still
+// lists it. A self-CopyObject was tried here before and real S3 rejects
+// copying an object onto itself, which broke every repeat sync.
+func (s *S3Storage) Recycle(filename string) error {
+	s.mutex.Lock()
+	s.recycled = append(s.recycled, filename)
+	s.mutex.Unlock()
+	return nil
+}
+
+// Commit promotes every staged object into place and publishes a manifest.
+// Metadata (repomd.xml, primary.xml, ...) is always staged before the
+// packages it references, because StoreRepo processes metadata fully before
+// it starts downloading packages; promoting staged objects in reverse
+// staging order therefore promotes packages first, so a client that reads
+// the newly-live metadata never finds it pointing at a package that isn't
+// live yet.
+func (s *S3Storage) Commit() error {
+	s.mutex.Lock()
+	staged := s.staged
+	recycled := s.recycled
+	s.staged = nil
+	s.recycled = nil
+	s.mutex.Unlock()
+
+	for i := len(staged) - 1; i >= 0; i-- {
+		filename := staged[i]
+		_, err := s.client.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(s.config.Bucket),
+			CopySource: aws.String(s.config.Bucket + "/" + stagingPrefix + filename),
+			Key:        aws.String(filename),
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = s.client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(s.config.Bucket),
+			Key:    aws.String(stagingPrefix + filename),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest := strings.Join(append(staged, recycled...), "\n")
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(manifestKey),
+		Body:   strings.NewReader(manifest),
+	})
+	return err
+}
+
+// isNotFound returns true if err is an S3 "not found" style error
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package get
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// fakeS3Object is what fakeS3 keeps per key: the body plus whatever metadata
+// was last written to it (by Upload, or by a metadata-replacing CopyObject)
+type fakeS3Object struct {
+	body     []byte
+	metadata map[string]*string
+}
+
+// fakeS3 is an in-memory stand-in for *s3.S3 and *s3manager.Uploader,
+// recording every call so tests can assert on ordering
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string]fakeS3Object
+	calls   []string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string]fakeS3Object{}}
+}
+
+func (f *fakeS3) HeadObject(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	obj, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+	etag := fmt.Sprintf(`"%x"`, md5.Sum(obj.body))
+	return &s3.HeadObjectOutput{ETag: aws.String(etag), Metadata: obj.metadata}, nil
+}
+
+func (f *fakeS3) CopyObject(in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls = append(f.calls, "copy:"+*in.CopySource+"->"+*in.Key)
+
+	_, srcKey, _ := strings.Cut(*in.CopySource, "/")
+	src, ok := f.objects[srcKey]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "copy source not found", nil)
+	}
+
+	dst := fakeS3Object{body: src.body, metadata: src.metadata}
+	if in.MetadataDirective != nil && *in.MetadataDirective == s3.MetadataDirectiveReplace {
+		dst.metadata = in.Metadata
+	}
+	f.objects[*in.Key] = dst
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.calls = append(f.calls, "delete:"+*in.Key)
+	delete(f.objects, *in.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) Upload(in *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	body, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	f.objects[*in.Key] = fakeS3Object{body: body, metadata: in.Metadata}
+	f.calls = append(f.calls, "upload:"+*in.Key)
+	f.mutex.Unlock()
+	return &s3manager.UploadOutput{}, nil
+}
+
+func (f *fakeS3) body(key string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return string(f.objects[key].body)
+}
+
+func newTestS3Storage() (*S3Storage, *fakeS3) {
+	fake := newFakeS3()
+	storage := &S3Storage{
+		config:   S3Config{Bucket: "test-bucket"},
+		client:   fake,
+		uploader: fake,
+	}
+	return storage, fake
+}
+
+func stageObject(t *testing.T, storage *S3Storage, path string, content string) {
+	t.Helper()
+	consumer := storage.StoringMapper(path, "")
+	if err := consumer(ioutil.NopCloser(strings.NewReader(content))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3StorageCommitPromotesPackagesBeforeMetadata(t *testing.T) {
+	storage, fake := newTestS3Storage()
+
+	// processMetadata stages repomd.xml and primary.xml synchronously before
+	// StoreRepo ever stages a package, so staging order always has metadata first
+	stageObject(t, storage, "repodata/repomd.xml", "repomd-bytes")
+	stageObject(t, storage, "repodata/primary.xml", "primary-bytes")
+	stageObject(t, storage, "some-package.rpm", "package-bytes")
+
+	if err := storage.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var copies []string
+	for _, call := range fake.calls {
+		if strings.HasPrefix(call, "copy:") {
+			copies = append(copies, call)
+		}
+	}
+
+	want := []string{
+		// one metadata-replacing self-copy per staged object, from StoringMapper
+		"copy:test-bucket/" + stagingPrefix + "repodata/repomd.xml->" + stagingPrefix + "repodata/repomd.xml",
+		"copy:test-bucket/" + stagingPrefix + "repodata/primary.xml->" + stagingPrefix + "repodata/primary.xml",
+		"copy:test-bucket/" + stagingPrefix + "some-package.rpm->" + stagingPrefix + "some-package.rpm",
+		// then Commit promotes in reverse staging order: packages before metadata
+		"copy:test-bucket/" + stagingPrefix + "some-package.rpm->some-package.rpm",
+		"copy:test-bucket/" + stagingPrefix + "repodata/primary.xml->repodata/primary.xml",
+		"copy:test-bucket/" + stagingPrefix + "repodata/repomd.xml->repodata/repomd.xml",
+	}
+	if !reflect.DeepEqual(copies, want) {
+		t.Fatalf("got copy calls %v, want %v (packages must land before the metadata referencing them)", copies, want)
+	}
+
+	if fake.body("some-package.rpm") != "package-bytes" {
+		t.Errorf("package was not promoted to its live key")
+	}
+	if fake.body("repodata/repomd.xml") != "repomd-bytes" {
+		t.Errorf("metadata was not promoted to its live key")
+	}
+}
+
+func TestS3StorageRecycleDoesNotSelfCopy(t *testing.T) {
+	storage, fake := newTestS3Storage()
+	fake.objects["already-live.rpm"] = fakeS3Object{body: []byte("unchanged bytes")}
+
+	if err := storage.Recycle("already-live.rpm"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, call := range fake.calls {
+		if strings.HasPrefix(call, "copy:") {
+			t.Fatalf("Recycle must not issue a CopyObject call (real S3 rejects copying an object onto itself), got %v", call)
+		}
+	}
+
+	if err := storage.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := fake.body(manifestKey)
+	if !strings.Contains(manifest, "already-live.rpm") {
+		t.Errorf("manifest %q does not list the recycled package", manifest)
+	}
+}
+
+func TestS3StorageStoringMapperRejectsChecksumMismatch(t *testing.T) {
+	storage, fake := newTestS3Storage()
+
+	wrongChecksum := strings.Repeat("0", sha256.Size*2)
+	consumer := storage.StoringMapper("bad.rpm", wrongChecksum)
+	err := consumer(ioutil.NopCloser(strings.NewReader("actual content")))
+	if err == nil {
+		t.Fatal("expected a checksum mismatch error, got nil")
+	}
+
+	if _, ok := fake.objects[stagingPrefix+"bad.rpm"]; ok {
+		t.Errorf("a checksum-mismatched upload should have been removed from staging")
+	}
+}
+
+func TestS3StorageStoringMapperAcceptsMatchingChecksum(t *testing.T) {
+	storage, fake := newTestS3Storage()
+
+	const content = "actual content"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	consumer := storage.StoringMapper("good.rpm", checksum)
+	if err := consumer(ioutil.NopCloser(strings.NewReader(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.body(stagingPrefix + "good.rpm"); got != content {
+		t.Errorf("got staged content %q, want %q", got, content)
+	}
+}
+
+func TestS3StorageChecksumReturnsRecordedHashNotETag(t *testing.T) {
+	storage, _ := newTestS3Storage()
+
+	const content = "actual content"
+	stageObject(t, storage, "good.rpm", content)
+	if err := storage.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	sha1Sum := sha1.Sum([]byte(content))
+	sha256Sum := sha256.Sum256([]byte(content))
+
+	got, err := storage.Checksum("good.rpm", SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(sha1Sum[:]); got != want {
+		t.Errorf("SHA1 Checksum = %q, want %q", got, want)
+	}
+
+	got, err = storage.Checksum("good.rpm", SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hex.EncodeToString(sha256Sum[:]); got != want {
+		t.Errorf("SHA256 Checksum = %q, want %q", got, want)
+	}
+
+	md5Sum := fmt.Sprintf("%x", md5.Sum([]byte(content)))
+	if got == md5Sum {
+		t.Errorf("Checksum returned the object's MD5 ETag instead of a recorded SHA checksum")
+	}
+}
+
+func TestS3StorageChecksumNotFound(t *testing.T) {
+	storage, _ := newTestS3Storage()
+
+	_, err := storage.Checksum("missing.rpm", SHA256)
+	if err != ErrFileNotFound {
+		t.Fatalf("got %v, want ErrFileNotFound", err)
+	}
+}
@@ -0,0 +1,55 @@
+package get
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// TrustPolicy controls repomd.xml signature verification for a Syncer
+type TrustPolicy struct {
+	// RequireSignature fails the sync if repomd.xml has no valid detached
+	// signature from a key in Keyring (or, if Keyring is nil, from the key
+	// the repo ships itself as repomd.xml.key)
+	RequireSignature bool
+	// Keyring is an armored OpenPGP keyring to verify repomd.xml.asc
+	// against. When nil, the repo-supplied repomd.xml.key is trusted instead.
+	Keyring io.Reader
+	// Fingerprints, if non-empty, additionally restricts which key
+	// fingerprints (hex-encoded, uppercase) are accepted as signers
+	Fingerprints map[string]bool
+}
+
+// verifySignature checks that signature is a valid detached OpenPGP
+// signature of data, by a key from p.Keyring, or from fallbackKeyring if
+// p.Keyring was not configured
+func (p TrustPolicy) verifySignature(data []byte, signature []byte, fallbackKeyring []byte) error {
+	keyringReader := p.Keyring
+	if keyringReader == nil {
+		if len(fallbackKeyring) == 0 {
+			return fmt.Errorf("no keyring available to verify repomd.xml signature")
+		}
+		keyringReader = bytes.NewReader(fallbackKeyring)
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(keyringReader)
+	if err != nil {
+		return fmt.Errorf("could not parse keyring: %w", err)
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(signature))
+	if err != nil {
+		return fmt.Errorf("repomd.xml signature verification failed: %w", err)
+	}
+
+	if len(p.Fingerprints) > 0 {
+		fingerprint := fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)
+		if !p.Fingerprints[fingerprint] {
+			return fmt.Errorf("repomd.xml was signed by untrusted key %v", fingerprint)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package get
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// newTestKeyring generates a fresh OpenPGP entity and returns it along with
+// its armored public keyring, for signing and verifying test data
+func newTestKeyring(t *testing.T) (*openpgp.Entity, []byte) {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("minima test", "", "minima-test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return entity, buf.Bytes()
+}
+
+// signTestData produces an armored detached signature of data by entity
+func signTestData(t *testing.T, entity *openpgp.Entity, data []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(buf, entity, bytes.NewReader(data), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifySignatureValid(t *testing.T) {
+	data := []byte("repomd.xml contents")
+	entity, keyring := newTestKeyring(t)
+	signature := signTestData(t, entity, data)
+
+	policy := TrustPolicy{Keyring: bytes.NewReader(keyring)}
+	if err := policy.verifySignature(data, signature, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySignatureCorrupted(t *testing.T) {
+	data := []byte("repomd.xml contents")
+	entity, keyring := newTestKeyring(t)
+	signature := signTestData(t, entity, data)
+	// flip a byte in the middle of the armored signature body, past the
+	// blank line that ends the "-----BEGIN PGP SIGNATURE-----" header
+	corrupted := append([]byte(nil), signature...)
+	bodyStart := bytes.Index(corrupted, []byte("\n\n")) + 2
+	i := bodyStart + (len(corrupted)-bodyStart)/4
+	if corrupted[i] == '\n' {
+		i++
+	}
+	if corrupted[i] == 'A' {
+		corrupted[i] = 'B'
+	} else {
+		corrupted[i] = 'A'
+	}
+
+	policy := TrustPolicy{Keyring: bytes.NewReader(keyring)}
+	if err := policy.verifySignature(data, corrupted, nil); err == nil {
+		t.Fatal("expected verification to fail for a corrupted signature")
+	}
+}
+
+func TestVerifySignatureUntrustedFingerprint(t *testing.T) {
+	data := []byte("repomd.xml contents")
+	entity, keyring := newTestKeyring(t)
+	signature := signTestData(t, entity, data)
+
+	policy := TrustPolicy{
+		Keyring:      bytes.NewReader(keyring),
+		Fingerprints: map[string]bool{"0000000000000000000000000000000000000000": true},
+	}
+	err := policy.verifySignature(data, signature, nil)
+	if err == nil {
+		t.Fatal("expected verification to fail for an untrusted signer")
+	}
+	if !strings.Contains(err.Error(), "untrusted key") {
+		t.Errorf("got error %q, want it to mention the untrusted key", err)
+	}
+}
+
+func TestVerifySignatureMissingKeyringAndNoFallback(t *testing.T) {
+	policy := TrustPolicy{}
+	err := policy.verifySignature([]byte("data"), []byte("signature"), nil)
+	if err == nil {
+		t.Fatal("expected an error when neither Keyring nor a fallback keyring is available")
+	}
+	if !strings.Contains(err.Error(), "no keyring available") {
+		t.Errorf("got error %q, want it to mention the missing keyring", err)
+	}
+}
@@ -2,52 +2,166 @@ package get
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path"
+
+	"github.com/moio/minima/util"
 )
 
-// Storage allows to store data in a local directory
-type Storage struct {
-	directory string
+// ChecksumType enumerates the checksum algorithms used in repo metadata
+type ChecksumType int
+
+const (
+	// SHA1 identifies the SHA-1 checksum algorithm
+	SHA1 ChecksumType = iota
+	// SHA256 identifies the SHA-256 checksum algorithm
+	SHA256
+)
+
+// ErrFileNotFound is returned by Storage.Checksum when the given path is not
+// yet present in the backend
+var ErrFileNotFound = errors.New("file not found")
+
+// Storage is implemented by anything that can receive a synced repo: a local
+// directory, an S3-compatible bucket, etc. Syncer talks to repos only through
+// this interface, so NewSyncer can be pointed at any backend that implements it.
+type Storage interface {
+	// StoringMapper returns a util.ReaderConsumer that stores the bytes read
+	// from a repo-relative path. When checksum is non-empty and its length
+	// matches a known algorithm (40 hex chars for SHA-1, 64 for SHA-256), the
+	// stored bytes are hashed as they are written and the consumer fails if
+	// they don't match checksum; a checksum of unrecognized length is stored
+	// without verification.
+	StoringMapper(path string, checksum string) util.ReaderConsumer
+
+	// Checksum returns the checksum of an already-stored path, or
+	// ErrFileNotFound if the path has not been stored yet
+	Checksum(path string, checksumType ChecksumType) (string, error)
+
+	// Recycle marks an already-stored path as still current, so a sync does
+	// not need to download it again
+	Recycle(path string) error
+
+	// Commit makes every path stored or recycled since the last Commit
+	// visible. It is not atomic across paths: S3Storage promotes staged
+	// objects one at a time and then publishes its manifest, while
+	// FilesystemStorage paths are already visible once stored
+	Commit() error
 }
 
-// NewStorage returns a new Storage given a local directory
-func NewStorage(directory string) *Storage {
-	return &Storage{directory}
+// newHash returns a fresh hash.Hash for checksumType
+func newHash(checksumType ChecksumType) hash.Hash {
+	if checksumType == SHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
 }
 
-// NewStoringReader returns a reader that will also store any read data to filename
-func (s *Storage) NewStoringReader(filename string, reader io.ReadCloser) (result io.ReadCloser, err error) {
-	file, err := os.Create(path.Join(s.directory, filename))
-	if err != nil {
-		return
+// checksumTypeFromLength infers the checksum algorithm from checksum's hex
+// length (40 chars for SHA-1, 64 for SHA-256), returning ok=false if checksum
+// is empty or of an unrecognized length
+func checksumTypeFromLength(checksum string) (checksumType ChecksumType, ok bool) {
+	switch len(checksum) {
+	case sha1.Size * 2:
+		return SHA1, true
+	case sha256.Size * 2:
+		return SHA256, true
+	default:
+		return 0, false
 	}
+}
 
-	writer := bufio.NewWriter(file)
-	teeReader := io.TeeReader(reader, writer)
+// newVerifyingHash returns a hash.Hash matching checksum's length (SHA-1 for
+// 40 hex chars, SHA-256 for 64), or nil if checksum is empty or of an
+// unrecognized length -- in which case the caller should skip verification
+func newVerifyingHash(checksum string) hash.Hash {
+	checksumType, ok := checksumTypeFromLength(checksum)
+	if !ok {
+		return nil
+	}
+	return newHash(checksumType)
+}
 
-	result = &storingReader{reader, writer, teeReader}
-	return
+// FilesystemStorage stores data in a local directory
+type FilesystemStorage struct {
+	directory string
 }
 
-// storingReader uses a TeeReader to copy data from a reader to a writer
-type storingReader struct {
-	reader    io.ReadCloser
-	writer    *bufio.Writer
-	teeReader io.Reader
+// NewFilesystemStorage returns a new Storage backed by a local directory
+func NewFilesystemStorage(directory string) *FilesystemStorage {
+	return &FilesystemStorage{directory}
 }
 
-// Read delegates to the TeeReader implementation
-func (t *storingReader) Read(p []byte) (n int, err error) {
-	return t.teeReader.Read(p)
+// StoringMapper stores the data read from reader into filename as it is read
+func (s *FilesystemStorage) StoringMapper(filename string, checksum string) util.ReaderConsumer {
+	return func(reader io.ReadCloser) (err error) {
+		defer reader.Close()
+
+		fullPath := path.Join(s.directory, filename)
+		file, err := os.Create(fullPath)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		writer := bufio.NewWriter(file)
+		target := io.Writer(writer)
+		h := newVerifyingHash(checksum)
+		if h != nil {
+			target = io.MultiWriter(writer, h)
+		}
+
+		if _, err = io.Copy(target, reader); err != nil {
+			return
+		}
+		if err = writer.Flush(); err != nil {
+			return
+		}
+
+		if h != nil {
+			if actual := hex.EncodeToString(h.Sum(nil)); actual != checksum {
+				os.Remove(fullPath)
+				return fmt.Errorf("checksum mismatch for %v: expected %v, got %v", filename, checksum, actual)
+			}
+		}
+		return
+	}
 }
 
-// Closes the internal reader and flushes the writer
-func (t *storingReader) Close() (err error) {
-	err = t.reader.Close()
+// Checksum computes the checksum of an already-stored path
+func (s *FilesystemStorage) Checksum(filename string, checksumType ChecksumType) (result string, err error) {
+	file, err := os.Open(path.Join(s.directory, filename))
 	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrFileNotFound
+		}
+		return
+	}
+	defer file.Close()
+
+	h := newHash(checksumType)
+	if _, err = io.Copy(h, file); err != nil {
 		return
 	}
-	return t.writer.Flush()
-}
\ No newline at end of file
+	result = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// Recycle is a no-op for FilesystemStorage: a package that is already on disk
+// does not need to move anywhere to stay there
+func (s *FilesystemStorage) Recycle(filename string) error {
+	return nil
+}
+
+// Commit is a no-op for FilesystemStorage: files are written to their final
+// path as they are downloaded, so there is nothing left to make visible
+func (s *FilesystemStorage) Commit() error {
+	return nil
+}
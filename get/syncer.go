@@ -1,18 +1,18 @@
 package get
 
 import (
-	"compress/gzip"
+	"bytes"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"strings"
-
-	"github.com/moio/minima/util"
 )
 
 // common
 
-// XMLLocation maps a <location> tag in repodata/repomd.xml or repodata/<ID>-primary.xml.gz
+// XMLLocation maps a <location> tag in repodata/repomd.xml or repodata/<ID>-primary.xml.{gz,zst,xz}
 type XMLLocation struct {
 	Href string `xml:"href,attr"`
 }
@@ -27,24 +27,25 @@ type XMLRepomd struct {
 // XMLData maps a <data> tag in repodata/repomd.xml
 type XMLData struct {
 	Type     string      `xml:"type,attr"`
+	Checksum XMLChecksum `xml:"checksum"`
 	Location XMLLocation `xml:"location"`
 }
 
-// repodata/<ID>-primary.xml.gz
+// repodata/<ID>-primary.xml.{gz,zst,xz}
 
-// XMLMetaData maps a <metadata> tag in repodata/<ID>-primary.xml.gz
+// XMLMetaData maps a <metadata> tag in repodata/<ID>-primary.xml.{gz,zst,xz}
 type XMLMetaData struct {
 	Packages []XMLPackage `xml:"package"`
 }
 
-// XMLPackage maps a <package> tag in repodata/<ID>-primary.xml.gz
+// XMLPackage maps a <package> tag in repodata/<ID>-primary.xml.{gz,zst,xz}
 type XMLPackage struct {
 	Arch     string      `xml:"arch"`
 	Location XMLLocation `xml:"location"`
 	Checksum XMLChecksum `xml:"checksum"`
 }
 
-// XMLChecksum maps a <checksum> tag in repodata/<ID>-primary.xml.gz
+// XMLChecksum maps a <checksum> tag in repodata/<ID>-primary.xml.{gz,zst,xz}
 type XMLChecksum struct {
 	Type     string `xml:"type,attr"`
 	Checksum string `xml:",cdata"`
@@ -58,17 +59,20 @@ var checksumTypeMap = map[string]ChecksumType{
 
 const repomdPath = "repodata/repomd.xml"
 
-// Syncer syncs repos from an HTTP source to a Storage
+// Syncer syncs RPM repos from an HTTP source to a Storage
 type Syncer struct {
-	// URL of the repo this syncer syncs
-	Url     string
-	archs   map[string]bool
-	storage Storage
+	repoBase
+	trustPolicy TrustPolicy
 }
 
-// NewSyncer creates a new Syncer
-func NewSyncer(url string, archs map[string]bool, storage Storage) *Syncer {
-	return &Syncer{url, archs, storage}
+// NewSyncer creates a new Syncer. parallelism is the number of packages
+// downloaded concurrently; values below 1 are treated as 1 (serial).
+// trustPolicy controls whether repomd.xml's signature must be verified.
+func NewSyncer(url string, archs map[string]bool, storage Storage, parallelism int, trustPolicy TrustPolicy) *Syncer {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &Syncer{repoBase{url, parallelism, archs, storage}, trustPolicy}
 }
 
 // StoreRepo stores an HTTP repo in a Storage
@@ -78,13 +82,15 @@ func (r *Syncer) StoreRepo() (err error) {
 		return
 	}
 
-	downloadCount := len(packagesToDownload)
-	log.Printf("Downloading %v packages...\n", downloadCount)
-	for _, pack := range packagesToDownload {
-		err = r.downloadStoreApply(pack.Location.Href, pack.Checksum.Checksum, util.Nop)
-		if err != nil {
-			return
-		}
+	refs := make([]downloadRef, len(packagesToDownload))
+	for i, pack := range packagesToDownload {
+		refs[i] = downloadRef{pack.Location.Href, pack.Checksum.Checksum}
+	}
+
+	log.Printf("Downloading %v packages with %v workers...\n", len(refs), r.parallelism)
+	err = r.downloadAll(refs)
+	if err != nil {
+		return
 	}
 
 	recycleCount := len(packagesToRecycle)
@@ -104,36 +110,35 @@ func (r *Syncer) StoreRepo() (err error) {
 	return
 }
 
-// downloadStore downloads a repo-relative path into a file
-func (r *Syncer) downloadStore(path string) error {
-	return r.downloadStoreApply(path, "", util.Nop)
-}
-
-// downloadStoreApply downloads a repo-relative path into a file, while applying a ReaderConsumer
-func (r *Syncer) downloadStoreApply(path string, checksum string, f util.ReaderConsumer) error {
-	log.Printf("Downloading %v...", path)
-	return DownloadApply(r.Url+"/"+path, util.Compose(r.storage.StoringMapper(path, checksum), f))
-}
-
 // processMetadata stores the repo metadata and returns a list of package file
-// paths to download
+// paths to download. Every metadata blob repomd.xml references must hash to
+// the checksum repomd.xml declares for it; if r.trustPolicy.RequireSignature
+// is set, repomd.xml must also carry a valid detached signature.
 func (r *Syncer) processMetadata() (packagesToDownload []XMLPackage, packagesToRecycle []XMLPackage, err error) {
+	var repomdBytes []byte
 	err = r.downloadStoreApply(repomdPath, "", func(reader io.ReadCloser) (err error) {
-		decoder := xml.NewDecoder(reader)
+		buf := &bytes.Buffer{}
+		decoder := xml.NewDecoder(io.TeeReader(reader, buf))
 		var repomd XMLRepomd
 		err = decoder.Decode(&repomd)
 		if err != nil {
 			return
 		}
+		repomdBytes = buf.Bytes()
 
 		data := repomd.Data
 		for i := 0; i < len(data); i++ {
 			metadataPath := data[i].Location.Href
+
 			if data[i].Type == "primary" {
-				packagesToDownload, packagesToRecycle, err = r.processPrimary(metadataPath)
-			} else {
-				err = r.downloadStore(metadataPath)
+				packagesToDownload, packagesToRecycle, err = r.processPrimary(metadataPath, data[i].Checksum.Checksum, data[i].Checksum.Type)
+				if err != nil {
+					return
+				}
+				continue
 			}
+
+			err = r.downloadStoreVerified(metadataPath, data[i].Checksum.Checksum, checksumTypeMap[data[i].Checksum.Type])
 			if err != nil {
 				return
 			}
@@ -144,22 +149,23 @@ func (r *Syncer) processMetadata() (packagesToDownload []XMLPackage, packagesToR
 		return
 	}
 
-	err = r.downloadStore(repomdPath + ".asc")
+	ascBytes, err := r.downloadBytesIgnoring404(repomdPath + ".asc")
 	if err != nil {
-		if strings.HasSuffix(err.Error(), "404") {
-			log.Printf("Got 404, ignoring...")
-			err = nil
-		} else {
-			return
-		}
+		return
 	}
 
-	err = r.downloadStore(repomdPath + ".key")
+	keyBytes, err := r.downloadBytesIgnoring404(repomdPath + ".key")
 	if err != nil {
-		if strings.HasSuffix(err.Error(), "404") {
-			log.Printf("Got 404, ignoring...")
-			err = nil
-		} else {
+		return
+	}
+
+	if r.trustPolicy.RequireSignature {
+		if len(ascBytes) == 0 {
+			err = errors.New("repomd.xml has no signature but one is required")
+			return
+		}
+		err = r.trustPolicy.verifySignature(repomdBytes, ascBytes, keyBytes)
+		if err != nil {
 			return
 		}
 	}
@@ -168,16 +174,33 @@ func (r *Syncer) processMetadata() (packagesToDownload []XMLPackage, packagesToR
 }
 
 // processPrimary stores the primary XML metadata file and returns a list of
-// package file paths to download
-func (r *Syncer) processPrimary(path string) (packagesToDownload []XMLPackage, packagesToRecycle []XMLPackage, err error) {
+// package file paths to download. The file may be gzip-, Zstandard- or
+// xz-compressed; openCompressed figures out which. If expectedChecksum is
+// non-empty, the downloaded bytes are hashed and compared against it before
+// anything in the file is decoded or acted upon, so a tampered or corrupted
+// blob is never trusted.
+func (r *Syncer) processPrimary(path string, expectedChecksum string, expectedChecksumType string) (packagesToDownload []XMLPackage, packagesToRecycle []XMLPackage, err error) {
 	err = r.downloadStoreApply(path, "", func(reader io.ReadCloser) (err error) {
-		gzReader, err := gzip.NewReader(reader)
+		buf := &bytes.Buffer{}
+		if _, err = io.Copy(buf, reader); err != nil {
+			return
+		}
+
+		if expectedChecksum != "" {
+			h := newHash(checksumTypeMap[expectedChecksumType])
+			h.Write(buf.Bytes())
+			if actual := hex.EncodeToString(h.Sum(nil)); actual != expectedChecksum {
+				return fmt.Errorf("checksum mismatch for %v: repomd.xml declares %v, got %v", path, expectedChecksum, actual)
+			}
+		}
+
+		decompressedReader, err := openCompressed(bytes.NewReader(buf.Bytes()))
 		if err != nil {
 			return
 		}
-		defer gzReader.Close()
+		defer decompressedReader.Close()
 
-		decoder := xml.NewDecoder(gzReader)
+		decoder := xml.NewDecoder(decompressedReader)
 		var primary XMLMetaData
 		err = decoder.Decode(&primary)
 		if err != nil {